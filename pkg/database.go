@@ -2,6 +2,8 @@ package pkg
 
 import (
 	"fmt"
+	"runtime"
+	"sort"
 	"sync"
 )
 
@@ -9,20 +11,135 @@ import (
 // It should always be clean i.e. properly settled
 // This also contains spme of the transaction state that is cleaned after every settlement
 type Database struct {
-	accounts  map[int]int                     // account id as key and amount as value
-	accountMu sync.RWMutex                    // mutex for preventing the accounts data structure
+	accounts  map[int]*Account // account id as key and its balance/reserve/lock state as value
+	accountMu sync.RWMutex     // mutex for preventing the accounts data structure
+
+	// existentialDeposit is the minimum free+reserved balance an account may hold without
+	// being emptied entirely; it is fixed for the lifetime of the database.
+	existentialDeposit int
+
+	// accountLocks holds one mutex per account ever referenced, used by LockAccounts to guard
+	// concurrent Settle batches against touching the same account at once.
+	accountLocks map[int]*sync.Mutex
+
+	// workerPoolSize bounds how many transactions within a single settle batch are applied
+	// concurrently; a value <= 0 means settle sequentially.
+	workerPoolSize int
 
 	// Intermediate states of the Accounts
 	transactionId       int                   // the global transaction Id
 	accountState        map[int]*AccountState // key is accountId and value is the state transactions affecting the account
+	pendingTransactions map[int]*Transaction  // key is the global transaction Id, value is the transaction as pushed
 	accountStateLock    sync.RWMutex          // mutex to make sure that the above DS is protected
 	appliedTransactions []int                 // stores all the applied transaction id
+
+	// Snapshot bookkeeping for nested, speculative transaction application
+	nextRevisionId int        // monotonic id handed out by Snapshot()
+	snapshots      []snapshot // stack of outstanding snapshots, oldest first
+
+	// Audit trail of balance changes produced by Settle
+	batchNum       int             // monotonic id of the last successful Settle, 0 before the first
+	updateRingSize int             // capacity of the updates ring; <= 0 falls back to defaultUpdateRingSize
+	updates        []AccountUpdate // ring of the most recent AccountUpdates, oldest first
+	updateSink     UpdateSink      // optional external sink every AccountUpdate is also forwarded to
+	updatesMu      sync.RWMutex    // mutex protecting the fields above
+}
+
+// defaultUpdateRingSize is the capacity of a Database's AccountUpdate ring when
+// DatabaseOptions.UpdateRingSize is left at its zero value.
+const defaultUpdateRingSize = 1024
+
+// DatabaseOptions configures the tunables fixed for the lifetime of a Database.
+type DatabaseOptions struct {
+	// ExistentialDeposit is the minimum free+reserved balance an account may hold without
+	// being reaped entirely.
+	ExistentialDeposit int
+
+	// WorkerPoolSize bounds how many non-conflicting transactions Settle applies concurrently
+	// within a single batch. Values <= 0 settle one transaction at a time.
+	WorkerPoolSize int
+
+	// UpdateRingSize bounds how many AccountUpdate records Settle retains in memory for
+	// GetAccountUpdates/GetBatchUpdates. Values <= 0 fall back to defaultUpdateRingSize.
+	UpdateRingSize int
+
+	// UpdateSink, if set, is notified of every AccountUpdate Settle emits, in addition to the
+	// in-memory ring, so that callers can persist the update stream to their own storage.
+	UpdateSink UpdateSink
+}
+
+// AccountUpdate describes a single account's balance change as the result of a Settle call,
+// modeled on Hermez's AccountUpdate: it lets a downstream consumer reconstruct balance history
+// without ever having to diff two full balance snapshots.
+type AccountUpdate struct {
+	BatchNum     int   // the Database.batchNum this update was produced by
+	AccountId    int   // the account whose balance changed
+	OldBalance   int   // free balance before this Settle
+	NewBalance   int   // free balance after this Settle, 0 if the account was reaped
+	AppliedTxIds []int // applied transactions, in ascending id order, that touched this account
+}
+
+// UpdateSink is a pluggable destination for the AccountUpdate stream Settle produces, so callers
+// can persist it (e.g. to a database or a REST-facing store) without Database knowing about any
+// particular storage backend.
+type UpdateSink interface {
+	OnAccountUpdate(update AccountUpdate)
+}
+
+// Account is the full balance model for a single account, inspired by Substrate's Balances
+// pallet: "free" is what ordinary transfers may move, "reserved" is money set aside (e.g. as
+// a bond or deposit) that transfers cannot touch, and "locks" further restrict how much of
+// "free" may move without removing it from the spendable balance.
+type Account struct {
+	free     int
+	reserved int
+	locks    []Lock
+}
+
+// Lock earmarks amount of an account's free balance against a reason until it is removed;
+// while active, a transfer may not push free below the largest active lock amount.
+type Lock struct {
+	id         string
+	amount     int
+	expiryTxId int // transaction id from which on the lock is no longer considered active, 0 if it never expires
+	reason     string
+}
+
+// maxLockAmount returns the largest amount among this account's locks that are still active
+// as of currentTxId, which is the floor that free may not drop below.
+func (a *Account) maxLockAmount(currentTxId int) int {
+	max := 0
+	for _, lock := range a.locks {
+		if lock.expiryTxId != 0 && lock.expiryTxId <= currentTxId {
+			continue
+		}
+		if lock.amount > max {
+			max = lock.amount
+		}
+	}
+	return max
+}
+
+// snapshot records, for every account touched so far, how many journal entries it had
+// at the time Snapshot() was called so that RevertToSnapshot can roll each account's
+// journal back to exactly that point.
+type snapshot struct {
+	id             int
+	journalLengths map[int]int // accountId -> len(accountState.transactions) at snapshot time
+	txId           int         // d.transactionId at snapshot time; pendingTransactions with a higher txId are undone by a revert to this snapshot
+}
+
+// journalEntry is a single pending transfer recorded against an account, keyed by the
+// global transaction id that produced it so it can be identified and undone individually.
+type journalEntry struct {
+	txId  int
+	delta int
 }
 
 // AccountState is a structure to maintain intermediate account state while applying transactions
 type AccountState struct {
 	initialBalance int
-	transactions   map[int]int // key is txId and value is list if transfers
+	transactions   []journalEntry // ordered journal of pending transfers affecting this account
 }
 
 // AccountBalances is a structure to transfer an account and its related detals during database creation
@@ -42,32 +159,273 @@ type Transfer struct {
 // database. i.e. all the transfers to the transaction should be either applied or not.
 type Transaction struct {
 	transfers []Transfer // The set of transfers that constitute this transaction
+
+	// fee and nonce are only consulted by TxPool: fee orders admission and eviction, and nonce
+	// sequences transactions against their "from" account (the first transfer's from). Both
+	// are zero-valued, and therefore equivalent to the first expected nonce and no fee, for
+	// transactions pushed directly against the Database rather than through a TxPool.
+	fee   int
+	nonce int
 }
 
-// CreateDatabase create the database instance with the given state of the accounts and their balances
-// If an acount is already found in the database, the old amount will be overwritten
-func CreateDatabase(accountsToAdd []AccountBalances) *Database {
+// CreateDatabase create the database instance with the given state of the accounts and their balances.
+// If an acount is already found in the database, the old amount will be overwritten. options fixes the
+// existential deposit and the settle worker pool size for the lifetime of the returned database.
+func CreateDatabase(accountsToAdd []AccountBalances, options DatabaseOptions) *Database {
 
 	// create the database object
 	database := &Database{
-		accounts:      make(map[int]int),
-		accountState:  make(map[int]*AccountState),
-		transactionId: 0,
+		accounts:           make(map[int]*Account),
+		accountState:       make(map[int]*AccountState),
+		accountLocks:       make(map[int]*sync.Mutex),
+		transactionId:      0,
+		existentialDeposit: options.ExistentialDeposit,
+		workerPoolSize:     options.WorkerPoolSize,
+		updateRingSize:     options.UpdateRingSize,
+		updateSink:         options.UpdateSink,
 	}
 
 	// populate the given accounts and their balances
 	for _, accountBalances := range accountsToAdd {
-		if _, ok := database.accounts[accountBalances.accountId]; ok {
-			// if the accountis already present.. overwrite it with the new data
-			database.accounts[accountBalances.accountId] = accountBalances.balance
-			continue
-		}
-		database.accounts[accountBalances.accountId] = accountBalances.balance
+		// if the account is already present.. overwrite it with the new data
+		database.accounts[accountBalances.accountId] = &Account{free: accountBalances.balance}
 	}
 
 	return database
 }
 
+// LockedAccountsResults holds the per-account locks taken for a batch of transactions so that
+// Settle can apply several non-conflicting transactions concurrently without two goroutines
+// touching the same account, modeled on Solana's LockedAccountsResults. Callers must call
+// Unlock() when done; a finalizer is also registered as a backstop so a panic between
+// LockAccounts and Unlock cannot leave accounts permanently locked.
+type LockedAccountsResults struct {
+	db       *Database
+	accounts []int
+	unlocked bool
+}
+
+// LockAccounts locks every account referenced by txs, in a fixed ascending order so that
+// concurrent callers locking overlapping account sets can never deadlock against each other.
+func (d *Database) LockAccounts(txs []*Transaction) *LockedAccountsResults {
+
+	accountSet := make(map[int]bool)
+	for _, tx := range txs {
+		for _, transfer := range tx.transfers {
+			accountSet[transfer.from] = true
+			accountSet[transfer.to] = true
+		}
+	}
+
+	accounts := make([]int, 0, len(accountSet))
+	for accountId := range accountSet {
+		accounts = append(accounts, accountId)
+	}
+	return d.lockAccountIds(accounts)
+}
+
+// lockAccountIds locks every account in accountIds, in a fixed ascending order so that
+// concurrent callers locking overlapping account sets can never deadlock against each other.
+// It is the same primitive LockAccounts uses to keep Settle's batch workers from racing each
+// other, so any other accessor that reads or mutates an Account's fields must go through it
+// too in order to actually exclude a concurrent Settle.
+func (d *Database) lockAccountIds(accountIds []int) *LockedAccountsResults {
+
+	accounts := append([]int(nil), accountIds...)
+	sort.Ints(accounts)
+
+	for _, accountId := range accounts {
+		d.lockFor(accountId).Lock()
+	}
+
+	results := &LockedAccountsResults{db: d, accounts: accounts}
+	runtime.SetFinalizer(results, func(r *LockedAccountsResults) { r.Unlock() })
+	return results
+}
+
+// Unlock releases every lock taken by the LockAccounts call that returned results. It is safe
+// to call more than once.
+func (r *LockedAccountsResults) Unlock() {
+	if r.unlocked {
+		return
+	}
+	r.unlocked = true
+
+	for _, accountId := range r.accounts {
+		r.db.lockFor(accountId).Unlock()
+	}
+	runtime.SetFinalizer(r, nil)
+}
+
+// lockFor returns the mutex guarding accountId, creating it on first use.
+func (d *Database) lockFor(accountId int) *sync.Mutex {
+	d.accountMu.Lock()
+	defer d.accountMu.Unlock()
+
+	mu, ok := d.accountLocks[accountId]
+	if !ok {
+		mu = &sync.Mutex{}
+		d.accountLocks[accountId] = mu
+	}
+	return mu
+}
+
+// Reserve moves amount out of accountId's free balance into its reserved balance, e.g. to
+// back a bond or deposit that should not be spendable by ordinary transfers. It takes
+// accountId's per-account lock, the same one Settle's batch workers take, so it cannot race a
+// concurrent settlement touching the same account.
+func (d *Database) Reserve(accountId, amount int) error {
+	locked := d.lockAccountIds([]int{accountId})
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	account, ok := d.accounts[accountId]
+	d.accountMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("account %d not present", accountId)
+	}
+	if amount < 0 {
+		return fmt.Errorf("cannot reserve a negative amount %d", amount)
+	}
+	if account.free < amount {
+		return fmt.Errorf("account %d has insufficient free balance %d to reserve %d", accountId, account.free, amount)
+	}
+
+	account.free -= amount
+	account.reserved += amount
+	return nil
+}
+
+// Unreserve moves up to amount out of accountId's reserved balance back into its free
+// balance, clamping to what is actually reserved. It takes accountId's per-account lock, the
+// same one Settle's batch workers take, so it cannot race a concurrent settlement touching
+// the same account.
+func (d *Database) Unreserve(accountId, amount int) error {
+	locked := d.lockAccountIds([]int{accountId})
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	account, ok := d.accounts[accountId]
+	d.accountMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("account %d not present", accountId)
+	}
+	if amount < 0 {
+		return fmt.Errorf("cannot unreserve a negative amount %d", amount)
+	}
+	if amount > account.reserved {
+		amount = account.reserved
+	}
+
+	account.reserved -= amount
+	account.free += amount
+	return nil
+}
+
+// RepatriateReserved moves amount directly out of from's reserved balance into to's free
+// balance, without it ever passing through from's free balance. It takes both accounts'
+// per-account locks, the same ones Settle's batch workers take, so it cannot race a
+// concurrent settlement touching either account.
+func (d *Database) RepatriateReserved(from, to, amount int) error {
+	locked := d.lockAccountIds([]int{from, to})
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	fromAccount, fromOk := d.accounts[from]
+	toAccount, toOk := d.accounts[to]
+	d.accountMu.RUnlock()
+	if !fromOk {
+		return fmt.Errorf("account %d not present", from)
+	}
+	if !toOk {
+		return fmt.Errorf("account %d not present", to)
+	}
+	if amount < 0 {
+		return fmt.Errorf("cannot repatriate a negative amount %d", amount)
+	}
+	if fromAccount.reserved < amount {
+		return fmt.Errorf("account %d has insufficient reserved balance %d to repatriate %d", from, fromAccount.reserved, amount)
+	}
+
+	fromAccount.reserved -= amount
+	toAccount.free += amount
+	return nil
+}
+
+// SetLock creates or updates the lock identified by id on accountId's free balance so that it
+// may not drop below amount while the lock is active. It takes accountId's per-account lock,
+// the same one Settle's batch workers take, so it cannot race a concurrent settlement
+// touching the same account.
+func (d *Database) SetLock(accountId int, id string, amount int) error {
+	locked := d.lockAccountIds([]int{accountId})
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	account, ok := d.accounts[accountId]
+	d.accountMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("account %d not present", accountId)
+	}
+
+	for i, lock := range account.locks {
+		if lock.id == id {
+			account.locks[i].amount = amount
+			return nil
+		}
+	}
+	account.locks = append(account.locks, Lock{id: id, amount: amount})
+	return nil
+}
+
+// RemoveLock removes the lock identified by id from accountId, if present. It takes
+// accountId's per-account lock, the same one Settle's batch workers take, so it cannot race a
+// concurrent settlement touching the same account.
+func (d *Database) RemoveLock(accountId int, id string) error {
+	locked := d.lockAccountIds([]int{accountId})
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	account, ok := d.accounts[accountId]
+	d.accountMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("account %d not present", accountId)
+	}
+
+	for i, lock := range account.locks {
+		if lock.id == id {
+			account.locks = append(account.locks[:i], account.locks[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// TotalIssuance sums free and reserved balances across every account still present in the
+// database, so that callers can check it is conserved across settlements. It takes every
+// account's per-account lock, the same ones Settle's batch workers take, so a settlement
+// cannot be caught mutating a balance mid-sum.
+func (d *Database) TotalIssuance() int {
+	d.accountMu.RLock()
+	accountIds := make([]int, 0, len(d.accounts))
+	for accountId := range d.accounts {
+		accountIds = append(accountIds, accountId)
+	}
+	d.accountMu.RUnlock()
+
+	locked := d.lockAccountIds(accountIds)
+	defer locked.Unlock()
+
+	d.accountMu.RLock()
+	defer d.accountMu.RUnlock()
+
+	total := 0
+	for _, accountId := range accountIds {
+		if account, ok := d.accounts[accountId]; ok {
+			total += account.free + account.reserved
+		}
+	}
+	return total
+}
 
 // PushTransaction receives the transaction and store it in the account state store for the
 // affected accounts along with the transaction references
@@ -77,6 +435,28 @@ func (d *Database) PushTransaction(transactionToPush *Transaction) error {
 	d.accountStateLock.Lock()
 	defer d.accountStateLock.Unlock()
 
+	return d.pushTransactionLocked(transactionToPush)
+}
+
+// PushTransactionWithSnapshot pushes the transaction exactly like PushTransaction and, if it
+// is accepted, additionally records a snapshot of the journal right after it is applied. The
+// returned revision id can later be handed to RevertToSnapshot to cheaply discard every
+// transaction pushed after this one, while keeping this transaction and earlier pending work.
+func (d *Database) PushTransactionWithSnapshot(transactionToPush *Transaction) (int, error) {
+
+	d.accountStateLock.Lock()
+	defer d.accountStateLock.Unlock()
+
+	if err := d.pushTransactionLocked(transactionToPush); err != nil {
+		return 0, err
+	}
+
+	return d.snapshotLocked(), nil
+}
+
+// pushTransactionLocked contains the actual push logic and assumes accountStateLock is held.
+func (d *Database) pushTransactionLocked(transactionToPush *Transaction) error {
+
 	// find the transaction Id and initialise the account state
 	if d.accountState == nil {
 		d.accountState = make(map[int]*AccountState)
@@ -97,26 +477,22 @@ func (d *Database) PushTransaction(transactionToPush *Transaction) error {
 	for _, transfer := range transactionToPush.transfers {
 
 		// "from" accounts collection
-		if bal, ok := d.accounts[transfer.from]; ok {
-			// create the "from" account state if it is not present already and add the current amount at index 0
+		if account, ok := d.accounts[transfer.from]; ok {
+			// create the "from" account state if it is not present already and add the current free balance at index 0
 			if _, ok := d.accountState[transfer.from]; !ok {
-				d.accountState[transfer.from] = &AccountState{
-					transactions: make(map[int]int),
-				}
-				d.accountState[transfer.from].initialBalance = bal
+				d.accountState[transfer.from] = &AccountState{}
+				d.accountState[transfer.from].initialBalance = account.free
 			}
 		} else {
 			return fmt.Errorf("source account %d not present. Ignoring the entire transaction", transfer.from)
 		}
 
 		// "to" accounts collection
-		if bal, ok := d.accounts[transfer.to]; ok {
-			// create the "to" account state if it is not present already  and add the current amount at index 0
+		if account, ok := d.accounts[transfer.to]; ok {
+			// create the "to" account state if it is not present already  and add the current free balance at index 0
 			if _, ok := d.accountState[transfer.to]; !ok {
-				d.accountState[transfer.to] = &AccountState{
-					transactions: make(map[int]int),
-				}
-				d.accountState[transfer.to].initialBalance = bal
+				d.accountState[transfer.to] = &AccountState{}
+				d.accountState[transfer.to].initialBalance = account.free
 			}
 		} else {
 			return fmt.Errorf("destnation account %d not present. Ignoring the entire transaction", transfer.to)
@@ -132,15 +508,93 @@ func (d *Database) PushTransaction(transactionToPush *Transaction) error {
 		txBal[transfer.to] = txBal[transfer.to] + transfer.amount
 	}
 
-	// store the transaction result in the respective accounts states
+	// store the transaction result in the respective accounts states, in order, so it can
+	// later be identified and undone by RevertToSnapshot
 	for accountId, bal := range txBal {
-		d.accountState[accountId].transactions[d.transactionId] = bal
+		state := d.accountState[accountId]
+		state.transactions = append(state.transactions, journalEntry{txId: d.transactionId, delta: bal})
+	}
+
+	// keep the original transaction around, keyed by its global id, so Settle can later group
+	// and apply it by its actual read/write set rather than just its per-account deltas
+	if d.pendingTransactions == nil {
+		d.pendingTransactions = make(map[int]*Transaction)
+	}
+	d.pendingTransactions[d.transactionId] = transactionToPush
+
+	return nil
+}
+
+// Snapshot records the current length of every account's pending journal under a fresh,
+// monotonically increasing revision id and returns that id. It is modeled on Ethereum's
+// StateDB.Snapshot(): callers may push further speculative transactions and later call
+// RevertToSnapshot with the returned id to undo exactly the transactions pushed since.
+func (d *Database) Snapshot() int {
+	d.accountStateLock.Lock()
+	defer d.accountStateLock.Unlock()
+
+	return d.snapshotLocked()
+}
+
+// snapshotLocked contains the actual snapshot logic and assumes accountStateLock is held.
+func (d *Database) snapshotLocked() int {
+	journalLengths := make(map[int]int, len(d.accountState))
+	for accountId, state := range d.accountState {
+		journalLengths[accountId] = len(state.transactions)
+	}
+
+	id := d.nextRevisionId
+	d.nextRevisionId++
+	d.snapshots = append(d.snapshots, snapshot{id: id, journalLengths: journalLengths, txId: d.transactionId})
+
+	return id
+}
+
+// RevertToSnapshot rolls every account's pending journal back to the length it had when
+// Snapshot() returned id, discarding any transfers pushed since while leaving earlier
+// pending work and already-settled balances untouched. It also discards the pushed
+// transactions themselves, keyed by global txId, so Settle - which walks pendingTransactions
+// to find what to apply - can never resurface one a revert already undid. The snapshot and
+// any later ones are then forgotten, mirroring StateDB.RevertToSnapshot().
+func (d *Database) RevertToSnapshot(id int) error {
+	d.accountStateLock.Lock()
+	defer d.accountStateLock.Unlock()
+
+	idx := -1
+	for i, s := range d.snapshots {
+		if s.id == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return fmt.Errorf("snapshot %d not found", id)
+	}
+
+	target := d.snapshots[idx]
+	for accountId, state := range d.accountState {
+		length := target.journalLengths[accountId]
+		if length < len(state.transactions) {
+			state.transactions = state.transactions[:length]
+		}
 	}
 
+	for txId := range d.pendingTransactions {
+		if txId > target.txId {
+			delete(d.pendingTransactions, txId)
+		}
+	}
+
+	// this snapshot and anything taken after it are no longer valid revert targets
+	d.snapshots = d.snapshots[:idx]
+
 	return nil
 }
 
-// Settle goes through all the state store and commits the balances to the affected accounts atomically
+// Settle goes through all the state store and commits the balances to the affected accounts
+// atomically. Non-conflicting transactions - ones that share no account - are grouped into
+// batches and applied concurrently by a worker pool, modeled on Solana's LockedAccountsResults:
+// each batch acquires only the accounts its own transactions touch, so batches never race.
 func (d *Database) Settle() error {
 
 	// check if there is anything left to settle
@@ -148,45 +602,284 @@ func (d *Database) Settle() error {
 		return fmt.Errorf("nothing to settele")
 	}
 
-	// protect this unsettled accounts by a lock until we collect and apply the transaction
+	// protect the unsettled accounts by a lock until the transactions are collected, batched and applied
 	d.accountStateLock.Lock()
-	d.accountMu.Lock()
 
 	// clear all the state at the end whether the settlement is done or not
 	defer func() {
 		d.accountStateLock.Unlock()
-		d.accountMu.Unlock()
 		d.accountState = nil
+		d.pendingTransactions = nil
 		d.transactionId = 0
+		d.snapshots = nil
+		d.nextRevisionId = 0
 	}()
 
-	// find all invalid transactions
+	// find all invalid transactions as a single-threaded pre-pass, ahead of any batching
 	ignoredTransactions := d.getInvalidTransactions()
 
-	// settle the accounts ignoring the invalid transactions
+	var pendingTxIds []int
+	for txId := range d.pendingTransactions {
+		if !ignoredTransactions[txId] {
+			pendingTxIds = append(pendingTxIds, txId)
+		}
+	}
+	sort.Ints(pendingTxIds)
+
+	batches := d.buildSettleBatches(pendingTxIds)
+
+	poolSize := d.workerPoolSize
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+
+	// capture the balance every touched account starts this settlement with, so the
+	// AccountUpdate emitted below can report a genuine before/after pair
+	oldBalances := make(map[int]int, len(d.accountState))
+	d.accountMu.RLock()
+	for accountId := range d.accountState {
+		if account, ok := d.accounts[accountId]; ok {
+			oldBalances[accountId] = account.free
+		}
+	}
+	d.accountMu.RUnlock()
+
 	appliedTx := make(map[int]bool)
-	for accountId, accountState := range d.accountState {
-		finalBalance := accountState.initialBalance
-		for txId, bal := range accountState.transactions {
-			if _, ok := ignoredTransactions[txId]; !ok {
-				finalBalance += bal
+	var appliedTxMu sync.Mutex
+
+	for _, batch := range batches {
+		semaphore := make(chan struct{}, poolSize)
+		var wg sync.WaitGroup
+
+		for _, txId := range batch {
+			wg.Add(1)
+			semaphore <- struct{}{}
+
+			go func(txId int) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				tx := d.pendingTransactions[txId]
+
+				locked := d.LockAccounts([]*Transaction{tx})
+				defer locked.Unlock()
+
+				for accountId, delta := range netAccountDeltas(tx) {
+					d.accounts[accountId].free += delta
+				}
+
+				appliedTxMu.Lock()
 				appliedTx[txId] = true
-			}
+				appliedTxMu.Unlock()
+			}(txId)
+		}
+
+		// a batch is conflict-free by construction, but the next batch may touch accounts this
+		// one just settled, so it must wait for every transaction in this batch to finish
+		wg.Wait()
+	}
+
+	// reap any account actually touched by an applied transaction this settlement that has
+	// been fully drained of both free and reserved funds, rather than leave it behind as empty
+	// bookkeeping. An account merely referenced by a transaction that was rejected as invalid
+	// must never be reaped here: it was never touched, however zero its balance happens to be.
+	touchedByApplied := make(map[int]bool)
+	for txId := range appliedTx {
+		for accountId := range accountsTouchedBy(d.pendingTransactions[txId]) {
+			touchedByApplied[accountId] = true
+		}
+	}
+
+	d.accountMu.Lock()
+	for accountId := range touchedByApplied {
+		if account, ok := d.accounts[accountId]; ok && account.free == 0 && account.reserved == 0 {
+			delete(d.accounts, accountId)
 		}
-		d.accounts[accountId] = finalBalance
 	}
+	d.accountMu.Unlock()
 
 	d.appliedTransactions = nil
-	for txId, _ := range appliedTx {
+	for txId := range appliedTx {
 		d.appliedTransactions = append(d.appliedTransactions, txId)
 	}
+	sort.Ints(d.appliedTransactions)
+
+	d.batchNum++
+	d.emitAccountUpdates(oldBalances, appliedTx)
 
 	return nil
 }
 
-// GetBalances returns the accounts and their current balances
+// emitAccountUpdates compares oldBalances, captured before this Settle touched any account,
+// against the current balances, and records an AccountUpdate for every account that actually
+// changed - skipping accounts every one of whose pending transactions was rejected as invalid.
+func (d *Database) emitAccountUpdates(oldBalances map[int]int, appliedTx map[int]bool) {
+
+	accountIds := make([]int, 0, len(oldBalances))
+	for accountId := range oldBalances {
+		accountIds = append(accountIds, accountId)
+	}
+	sort.Ints(accountIds)
+
+	for _, accountId := range accountIds {
+		newBalance := 0
+		if account, ok := d.accounts[accountId]; ok {
+			newBalance = account.free
+		}
+		oldBalance := oldBalances[accountId]
+		if oldBalance == newBalance {
+			continue
+		}
+
+		appliedTxIds := accountTransfersTouching(d.pendingTransactions, appliedTx, accountId)
+		sort.Ints(appliedTxIds)
+
+		d.recordUpdate(AccountUpdate{
+			BatchNum:     d.batchNum,
+			AccountId:    accountId,
+			OldBalance:   oldBalance,
+			NewBalance:   newBalance,
+			AppliedTxIds: appliedTxIds,
+		})
+	}
+}
+
+// accountTransfersTouching returns the ids, out of appliedTx, of transactions in pendingTransactions
+// whose transfers reference accountId either as the "from" or the "to".
+func accountTransfersTouching(pendingTransactions map[int]*Transaction, appliedTx map[int]bool, accountId int) []int {
+	var txIds []int
+	for txId := range appliedTx {
+		tx := pendingTransactions[txId]
+		if accountsTouchedBy(tx)[accountId] {
+			txIds = append(txIds, txId)
+		}
+	}
+	return txIds
+}
+
+// recordUpdate appends update to the in-memory ring, trimming the oldest entry once the
+// configured capacity is exceeded, and forwards it to the configured UpdateSink, if any.
+func (d *Database) recordUpdate(update AccountUpdate) {
+	d.updatesMu.Lock()
+	ringSize := d.updateRingSize
+	if ringSize <= 0 {
+		ringSize = defaultUpdateRingSize
+	}
+	d.updates = append(d.updates, update)
+	if len(d.updates) > ringSize {
+		d.updates = d.updates[len(d.updates)-ringSize:]
+	}
+	sink := d.updateSink
+	d.updatesMu.Unlock()
+
+	if sink != nil {
+		sink.OnAccountUpdate(update)
+	}
+}
+
+// GetAccountUpdates returns, oldest first, every retained AccountUpdate for accountId with a
+// BatchNum strictly greater than sinceBatch. Pass sinceBatch 0 to fetch everything still retained.
+func (d *Database) GetAccountUpdates(accountId int, sinceBatch int) []AccountUpdate {
+	d.updatesMu.RLock()
+	defer d.updatesMu.RUnlock()
+
+	var updates []AccountUpdate
+	for _, update := range d.updates {
+		if update.AccountId == accountId && update.BatchNum > sinceBatch {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// GetBatchUpdates returns, in no particular account order, every retained AccountUpdate produced
+// by the Settle call whose batch number is batchNum.
+func (d *Database) GetBatchUpdates(batchNum int) []AccountUpdate {
+	d.updatesMu.RLock()
+	defer d.updatesMu.RUnlock()
+
+	var updates []AccountUpdate
+	for _, update := range d.updates {
+		if update.BatchNum == batchNum {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// netAccountDeltas computes, for a single transaction, the net balance change per account
+// across all of its transfers - the same aggregation PushTransaction performs before journaling.
+func netAccountDeltas(tx *Transaction) map[int]int {
+	deltas := make(map[int]int)
+	for _, transfer := range tx.transfers {
+		deltas[transfer.from] -= transfer.amount
+		deltas[transfer.to] += transfer.amount
+	}
+	return deltas
+}
+
+// buildSettleBatches groups txIds into batches such that no two transactions in the same batch
+// touch a common account, using a greedy first-fit placement over each transaction's read/write
+// set (the union of its transfers' from and to accounts). txIds is expected to already be in a
+// fixed order so batching is deterministic given the same pending state.
+func (d *Database) buildSettleBatches(txIds []int) [][]int {
+
+	var batches [][]int
+	var batchAccounts []map[int]bool
+
+	for _, txId := range txIds {
+		accounts := accountsTouchedBy(d.pendingTransactions[txId])
+
+		placed := false
+		for i, used := range batchAccounts {
+			if accountSetsIntersect(used, accounts) {
+				continue
+			}
+			batches[i] = append(batches[i], txId)
+			for accountId := range accounts {
+				used[accountId] = true
+			}
+			placed = true
+			break
+		}
+
+		if !placed {
+			batches = append(batches, []int{txId})
+			batchAccounts = append(batchAccounts, accounts)
+		}
+	}
+
+	return batches
+}
+
+// accountsTouchedBy returns the set of accounts referenced, as either a "from" or a "to", by
+// any transfer in tx.
+func accountsTouchedBy(tx *Transaction) map[int]bool {
+	accounts := make(map[int]bool)
+	for _, transfer := range tx.transfers {
+		accounts[transfer.from] = true
+		accounts[transfer.to] = true
+	}
+	return accounts
+}
+
+// accountSetsIntersect reports whether a and b share at least one account.
+func accountSetsIntersect(a, b map[int]bool) bool {
+	for accountId := range b {
+		if a[accountId] {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBalances returns the accounts and their current free balances
 func (d *Database) GetBalances() map[int]int {
-	return d.accounts
+	balances := make(map[int]int, len(d.accounts))
+	for accountId, account := range d.accounts {
+		balances[accountId] = account.free
+	}
+	return balances
 }
 
 // GetAppliedTransactions returnes the indexes of the applied transactions
@@ -194,41 +887,87 @@ func (d *Database) GetAppliedTransactions() []int {
 	return d.appliedTransactions
 }
 
-
-
-// getInvalidTransactions finds out transactions that will change the state in a invariant state
+// getInvalidTransactions finds the minimal set of pending transactions that must be rejected
+// so every account's final balance honours its invariants (non-negative, clear of any active
+// lock, clear of the existential deposit unless fully emptied). For each account, the journal
+// is already in ascending global txId order (transactions are appended as they are pushed), so
+// a single forward pass can simulate the running balance and mark every entry that would drive
+// it below the account's floor. Because a transaction is atomic across several transfers,
+// invalidating it on account A changes the running balance account B sees for the very same
+// transaction, so the per-account passes are repeated until a full pass finds nothing new.
+// Accounts are visited in a fixed, ascending accountId order on every pass so that, given the
+// same pending state, the same set of transactions is rejected every time.
 func (d *Database) getInvalidTransactions() map[int]bool {
 
+	accountIds := make([]int, 0, len(d.accountState))
+	for accountId := range d.accountState {
+		accountIds = append(accountIds, accountId)
+	}
+	sort.Ints(accountIds)
+
 	allInvalidTx := make(map[int]bool)
 
-	// check if all the state are valid
-	for _, accountState := range d.accountState {
-		finalBalance := accountState.initialBalance
-		for _, bal := range accountState.transactions {
-			finalBalance += bal
-		}
-
-		var invalidTransactions []int
-		if finalBalance < 0 {
-			// find invalid transaction by iterationg from the back to remove the -ve balances
-			balanceToRemove := finalBalance
-			for txId := len(accountState.transactions); txId > 0; txId-- {
-				bal := accountState.transactions[txId]
-				if bal < 0 {
-					invalidTransactions = append(invalidTransactions, txId)
-					balanceToRemove -= bal
-					if balanceToRemove > finalBalance {
-						break
-					}
+	for {
+		grew := false
+
+		for _, accountId := range accountIds {
+			accountState := d.accountState[accountId]
+			dustFloor, reserved := d.accountDustFloor(accountId)
+
+			balance := accountState.initialBalance
+			for _, entry := range accountState.transactions {
+				if allInvalidTx[entry.txId] {
+					continue
+				}
+
+				// the lock floor is evaluated as of this entry's own txId, not the batch-final
+				// d.transactionId, so a lock that expires partway through the batch still
+				// protects the transactions that preceded its expiry
+				lockFloor := d.accountLockFloor(accountId, entry.txId)
+
+				newBalance := balance + entry.delta
+				if newBalance < lockFloor || (newBalance+reserved > 0 && newBalance < dustFloor) {
+					allInvalidTx[entry.txId] = true
+					grew = true
+					continue
 				}
-			}
 
-			// add the invalid transaction to the global invalid transaction list
-			for _, txId := range invalidTransactions {
-				allInvalidTx[txId] = true
+				balance = newBalance
 			}
 		}
+
+		// the invalid set only grows and is bounded by the number of pending transactions,
+		// so this loop always terminates
+		if !grew {
+			break
+		}
 	}
 
 	return allInvalidTx
 }
+
+// accountLockFloor returns the largest amount among accountId's locks that are still active as
+// of currentTxId, which its free balance must not fall below.
+func (d *Database) accountLockFloor(accountId, currentTxId int) int {
+	account, ok := d.accounts[accountId]
+	if !ok {
+		return 0
+	}
+	return account.maxLockAmount(currentTxId)
+}
+
+// accountDustFloor returns the free balance below which accountId's free+reserved would fall
+// short of the existential deposit, along with its current reserved balance so callers can
+// gate the check on free+reserved rather than free alone: an account is only considered
+// "emptied entirely" - exempt from the dust floor - once both are driven to zero.
+func (d *Database) accountDustFloor(accountId int) (dustFloor, reserved int) {
+	account, ok := d.accounts[accountId]
+	if !ok {
+		return 0, 0
+	}
+
+	if account.reserved < d.existentialDeposit {
+		dustFloor = d.existentialDeposit - account.reserved
+	}
+	return dustFloor, account.reserved
+}
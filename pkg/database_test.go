@@ -1,7 +1,9 @@
 package pkg
 
 import (
+	"sync"
 	"testing"
+	"time"
 )
 
 type Result struct {
@@ -31,27 +33,32 @@ func TestDatabase(t *testing.T) {
 		{
 			name:    "Single failing transaction that roll back, TX1 - (tr1 valid)",
 			input1:  []int{3, 1, 5, 2, 10, 3, 15, 1, 1, 1, 2, 0},
-			output1: []int{3, 1, 15, 2, 10, 3, 15, 1, 1},
+			output1: []int{3, 1, 5, 2, 10, 3, 15, 1, 1},
 		},
 		{
-			name:    "Multiple transactions that restore consistency, TX2 - (tr1 valid, tr2 valid)",
+			// tr1 (2 -> 1, 11) transiently overdraws account 2 (10 - 11 < 0) and is rejected;
+			// tr2 (1 -> 2, 3 then 3 -> 1, 2) is unaffected and applied on its own
+			name:    "Multiple transactions that restore consistency, TX2 - (tr1 invalid, tr2 valid)",
 			input1:  []int{3, 1, 5, 2, 10, 3, 15, 2, 1, 2, 1, 11, 2, 1, 2, 3, 3, 1, 2},
-			output1: []int{3, 1, 15, 2, 2, 3, 13, 2, 1, 2},
+			output1: []int{3, 1, 4, 2, 13, 3, 13, 1, 2},
 		},
 		{
 			name:    "Multiple transaction with one invalid transaction that is ignored, TX3 - (tr1 valid, tr1 invalid, tr2 valid)",
 			input1:  []int{3, 1, 5, 2, 10, 3, 15, 3, 1, 2, 1, 11, 1, 2, 3, 5, 2, 1, 2, 3, 3, 1, 2},
-			output1: []int{3, 1, 15, 2, 2, 3, 13, 2, 1, 3},
+			output1: []int{3, 1, 4, 2, 8, 3, 18, 2, 2, 3},
 		},
 		{
 			name:    "Multiple transaction with one invalid transaction containing two transfers that is ignored, TX3 - (tr1 valid, tr2 invalid, tr2 valid)",
 			input1:  []int{3, 1, 5, 2, 10, 3, 15, 3, 1, 2, 1, 11, 2, 2, 3, 5, 3, 1, 2, 2, 1, 2, 3, 3, 1, 2},
-			output1: []int{3, 1, 15, 2, 2, 3, 13, 2, 1, 3},
+			output1: []int{3, 1, 6, 2, 8, 3, 16, 2, 2, 3},
 		},
 		{
-			name:    "Multiple transaction with one invalid transaction containing two transfers that is ignored, TX3 - (tr1 valid, tr2 invalid, tr2 valid)",
+			// tr1 (2 -> 1, 11) and tr2 (2 -> 3, 20 then 3 -> 1, 2) both transiently overdraw
+			// account 2 and are rejected; tr3 (1 -> 2, 3 then 3 -> 1, 2) is unaffected and
+			// applied on its own
+			name:    "Multiple transaction with two invalid transactions that are ignored, TX3 - (tr1 invalid, tr2 invalid, tr3 valid)",
 			input1:  []int{3, 1, 5, 2, 10, 3, 15, 3, 1, 2, 1, 11, 2, 2, 3, 20, 3, 1, 2, 2, 1, 2, 3, 3, 1, 2},
-			output1: []int{3, 1, 15, 2, 2, 3, 13, 2, 1, 3},
+			output1: []int{3, 1, 4, 2, 13, 3, 13, 1, 3},
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
@@ -61,14 +68,481 @@ func TestDatabase(t *testing.T) {
 			}
 
 			for i, expected := range tc.output1 {
-				if tc.output1[i] != expected {
-					t.Fatalf("expected %d, got %d", expected, tc.output1[i])
+				if result.output1[i] != expected {
+					t.Fatalf("expected %d, got %d", expected, result.output1[i])
 				}
 			}
 		})
 	}
 }
 
+func TestDatabase_SnapshotRevert(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+
+	// tr1 is pushed and kept
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 4}}}); err != nil {
+		t.Fatalf("unexpected error pushing tr1: %v", err)
+	}
+
+	rev := db.Snapshot()
+
+	// tr2 and tr3 are pushed speculatively and should be fully undone by the revert below
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 3, amount: 3}}}); err != nil {
+		t.Fatalf("unexpected error pushing tr2: %v", err)
+	}
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 2, to: 3, amount: 1}}}); err != nil {
+		t.Fatalf("unexpected error pushing tr3: %v", err)
+	}
+
+	if err := db.RevertToSnapshot(rev); err != nil {
+		t.Fatalf("unexpected error reverting to snapshot: %v", err)
+	}
+
+	// tr4 is pushed after the revert and should be the only thing settled alongside tr1
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 3, amount: 2}}}); err != nil {
+		t.Fatalf("unexpected error pushing tr4: %v", err)
+	}
+
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 4 || balances[2] != 4 || balances[3] != 2 {
+		t.Fatalf("unexpected balances after revert: %v", balances)
+	}
+	if len(db.GetAppliedTransactions()) != 2 {
+		t.Fatalf("expected 2 applied transactions, got %d", len(db.GetAppliedTransactions()))
+	}
+}
+
+func TestDatabase_ReserveUnreserveRepatriate(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+
+	if err := db.Reserve(1, 6); err != nil {
+		t.Fatalf("unexpected error reserving: %v", err)
+	}
+	if err := db.Reserve(1, 6); err == nil {
+		t.Fatalf("expected an error reserving more than the free balance")
+	}
+
+	if err := db.Unreserve(1, 2); err != nil {
+		t.Fatalf("unexpected error unreserving: %v", err)
+	}
+
+	if err := db.RepatriateReserved(1, 2, 4); err != nil {
+		t.Fatalf("unexpected error repatriating reserved funds: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 6 || balances[2] != 4 {
+		t.Fatalf("unexpected free balances: %v", balances)
+	}
+	if total := db.TotalIssuance(); total != 10 {
+		t.Fatalf("expected total issuance to be conserved at 10, got %d", total)
+	}
+}
+
+func TestDatabase_ReserveDoesNotRaceAConcurrentSettle(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 100},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 100},
+	}, DatabaseOptions{ExistentialDeposit: 0, WorkerPoolSize: 4})
+
+	// account 3 is settled concurrently with Reserve/Unreserve calls against account 1; they
+	// share no account, but both paths must still go through the same per-account lock that
+	// guards Account's fields for this not to race under -race
+	for i := 0; i < 20; i++ {
+		if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 3, to: 2, amount: 1}}}); err != nil {
+			t.Fatalf("unexpected error pushing settle filler tx %d: %v", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		if err := db.Settle(); err != nil {
+			t.Errorf("unexpected error settling: %v", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := db.Reserve(1, 1); err != nil {
+				t.Errorf("unexpected error reserving: %v", err)
+			}
+			if err := db.Unreserve(1, 1); err != nil {
+				t.Errorf("unexpected error unreserving: %v", err)
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestDatabase_LockPreventsOverdraftOfLockedFunds(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+
+	if err := db.SetLock(1, "staking", 7); err != nil {
+		t.Fatalf("unexpected error setting lock: %v", err)
+	}
+
+	// this transfer would push free to 4, below the 7 locked, so it must be rejected
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 6}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 10 || balances[2] != 0 {
+		t.Fatalf("expected the locked transfer to be rejected, got balances %v", balances)
+	}
+
+	if err := db.RemoveLock(1, "staking"); err != nil {
+		t.Fatalf("unexpected error removing lock: %v", err)
+	}
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 6}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances = db.GetBalances()
+	if balances[1] != 4 || balances[2] != 6 {
+		t.Fatalf("expected the transfer to succeed once the lock was removed, got balances %v", balances)
+	}
+}
+
+func TestDatabase_ExistentialDepositReapsEmptiedAccount(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 5})
+
+	// leaving 2 behind would be dust below the existential deposit of 5, so this must be rejected
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 8}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+	if balances := db.GetBalances(); balances[1] != 10 {
+		t.Fatalf("expected the dust-leaving transfer to be rejected, got balances %v", balances)
+	}
+
+	// emptying the account entirely is fine and reaps it
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 10}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if _, ok := balances[1]; ok {
+		t.Fatalf("expected account 1 to be reaped, got balances %v", balances)
+	}
+	if balances[2] != 10 {
+		t.Fatalf("unexpected balance for account 2: %v", balances)
+	}
+}
+
+func TestDatabase_ExistentialDepositCountsReservedBalanceToo(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 100}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 100})
+
+	if err := db.Reserve(1, 50); err != nil {
+		t.Fatalf("unexpected error reserving: %v", err)
+	}
+
+	// this would drain free to 0 while 50 stays reserved, leaving free+reserved at 50, still
+	// below the existential deposit of 100, so it must be rejected despite free alone hitting 0
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 50}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	if balances := db.GetBalances(); balances[1] != 50 {
+		t.Fatalf("expected the dust-leaving transfer to be rejected, got balances %v", balances)
+	}
+}
+
+func TestDatabase_LockFloorEvaluatedAsOfEachEntrysOwnTxId(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 10},
+		{accountId: 4, balance: 10},
+		{accountId: 5, balance: 10},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+
+	// the lock on account 1 expires once the global counter reaches txId 2
+	if err := db.SetLock(1, "vesting", 7); err != nil {
+		t.Fatalf("unexpected error setting lock: %v", err)
+	}
+	db.accounts[1].locks[0].expiryTxId = 2
+
+	// tx1: pushed while the lock is still active; it must stay protected by it even though
+	// later, unrelated transactions push the global counter past the lock's expiry
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 6}}}); err != nil {
+		t.Fatalf("unexpected error pushing tx1: %v", err)
+	}
+
+	// three unrelated filler transactions on other accounts push d.transactionId to 4, past
+	// the lock's expiry at txId 2
+	for _, transfer := range []Transfer{{from: 3, to: 4, amount: 1}, {from: 4, to: 5, amount: 1}, {from: 5, to: 3, amount: 1}} {
+		if err := db.PushTransaction(&Transaction{transfers: []Transfer{transfer}}); err != nil {
+			t.Fatalf("unexpected error pushing filler transaction: %v", err)
+		}
+	}
+
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	// tx1 would have pushed free to 4, below the 7 locked as of its own txId, so it must still
+	// be rejected despite the lock having since expired
+	if balances := db.GetBalances(); balances[1] != 10 {
+		t.Fatalf("expected tx1 to remain rejected by its contemporaneous lock, got balances %v", balances)
+	}
+}
+
+func TestDatabase_RejectingEarlierTxRescuesLaterTxOnAnotherAccount(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 5},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 3},
+		{accountId: 4, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+
+	// tx1 overdraws account 1 (5 - 10 < 0) but, being atomic, also drains account 3 from 3 to 0
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{
+		{from: 1, to: 2, amount: 10},
+		{from: 3, to: 4, amount: 3},
+	}}); err != nil {
+		t.Fatalf("unexpected error pushing tx1: %v", err)
+	}
+
+	// tx2 only needs 1 of account 3's balance, which is only available once tx1 is rejected
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 3, to: 4, amount: 1}}}); err != nil {
+		t.Fatalf("unexpected error pushing tx2: %v", err)
+	}
+
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 5 || balances[2] != 0 || balances[3] != 2 || balances[4] != 1 {
+		t.Fatalf("expected tx1 to be rejected and tx2 to be rescued, got balances %v", balances)
+	}
+	if applied := db.GetAppliedTransactions(); len(applied) != 1 || applied[0] != 2 {
+		t.Fatalf("expected only tx2 to be applied, got %v", applied)
+	}
+}
+
+func TestDatabase_InvalidDetectionSurvivesGapsInGlobalTxIds(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 5}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+
+	// this push is rejected before any journal entry is recorded, but it still consumes a
+	// global transaction id, leaving a gap
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 99, to: 2, amount: 1}}}); err == nil {
+		t.Fatalf("expected an error pushing a transaction against a non-existent account")
+	}
+
+	// this transaction lands on global txId 2, with txId 1 never present in any account's journal
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 10}}}); err != nil {
+		t.Fatalf("unexpected error pushing the overdrawing transaction: %v", err)
+	}
+
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 5 || balances[2] != 0 {
+		t.Fatalf("expected the overdrawing transaction to be rejected despite the txId gap, got balances %v", balances)
+	}
+	if applied := db.GetAppliedTransactions(); len(applied) != 0 {
+		t.Fatalf("expected nothing to be applied, got %v", applied)
+	}
+}
+
+func TestDatabase_SettleBatchesNonConflictingTransactionsConcurrently(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 10},
+		{accountId: 4, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0, WorkerPoolSize: 4})
+
+	// these two transactions touch disjoint accounts, so they belong to the same settle batch
+	// and should be applied by two different worker goroutines
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 4}}}); err != nil {
+		t.Fatalf("unexpected error pushing tx1: %v", err)
+	}
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 3, to: 4, amount: 6}}}); err != nil {
+		t.Fatalf("unexpected error pushing tx2: %v", err)
+	}
+
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 6 || balances[2] != 4 || balances[3] != 4 || balances[4] != 6 {
+		t.Fatalf("unexpected balances after batched settle: %v", balances)
+	}
+	if applied := db.GetAppliedTransactions(); len(applied) != 2 {
+		t.Fatalf("expected both transactions to be applied, got %v", applied)
+	}
+}
+
+func TestDatabase_LockAccountsPreventsConcurrentAccess(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+
+	tx := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}}
+	locked := db.LockAccounts([]*Transaction{tx})
+
+	acquired := make(chan struct{})
+	go func() {
+		second := db.LockAccounts([]*Transaction{tx})
+		second.Unlock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("expected a second LockAccounts call for the same accounts to block while the first is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	locked.Unlock()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the second LockAccounts call to proceed once the first was unlocked")
+	}
+}
+
+func TestDatabase_SettleEmitsAccountUpdatesForChangedBalancesOnly(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 0},
+		{accountId: 3, balance: 5}, // untouched by any transaction below
+	}, DatabaseOptions{ExistentialDeposit: 0})
+
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 4}}}); err != nil {
+		t.Fatalf("unexpected error pushing tx: %v", err)
+	}
+	if err := db.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	batchUpdates := db.GetBatchUpdates(1)
+	if len(batchUpdates) != 2 {
+		t.Fatalf("expected 2 account updates for batch 1, got %d: %v", len(batchUpdates), batchUpdates)
+	}
+
+	byAccount := make(map[int]AccountUpdate, len(batchUpdates))
+	for _, update := range batchUpdates {
+		byAccount[update.AccountId] = update
+	}
+
+	if update, ok := byAccount[1]; !ok || update.OldBalance != 10 || update.NewBalance != 6 || len(update.AppliedTxIds) != 1 || update.AppliedTxIds[0] != 1 {
+		t.Fatalf("unexpected update for account 1: %+v", update)
+	}
+	if update, ok := byAccount[2]; !ok || update.OldBalance != 0 || update.NewBalance != 4 || len(update.AppliedTxIds) != 1 || update.AppliedTxIds[0] != 1 {
+		t.Fatalf("unexpected update for account 2: %+v", update)
+	}
+	if _, ok := byAccount[3]; ok {
+		t.Fatalf("expected no account update for account 3, which was never touched")
+	}
+
+	if updates := db.GetAccountUpdates(1, 0); len(updates) != 1 || updates[0].BatchNum != 1 {
+		t.Fatalf("unexpected GetAccountUpdates(1, 0) result: %v", updates)
+	}
+	if updates := db.GetAccountUpdates(1, 1); len(updates) != 0 {
+		t.Fatalf("expected no updates strictly after batch 1, got %v", updates)
+	}
+}
+
+type recordingUpdateSink struct {
+	mu      sync.Mutex
+	updates []AccountUpdate
+}
+
+func (s *recordingUpdateSink) OnAccountUpdate(update AccountUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates = append(s.updates, update)
+}
+
+func TestDatabase_SettleForwardsAccountUpdatesToSinkAndBoundsTheRing(t *testing.T) {
+
+	sink := &recordingUpdateSink{}
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 100}, {accountId: 2, balance: 0}},
+		DatabaseOptions{ExistentialDeposit: 0, UpdateRingSize: 1, UpdateSink: sink})
+
+	for i := 0; i < 3; i++ {
+		if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}}); err != nil {
+			t.Fatalf("unexpected error pushing tx %d: %v", i, err)
+		}
+		if err := db.Settle(); err != nil {
+			t.Fatalf("unexpected error settling batch %d: %v", i, err)
+		}
+	}
+
+	// every settlement moved money between both accounts, so the sink should have seen
+	// 2 updates per batch regardless of how small the in-memory ring is
+	if len(sink.updates) != 6 {
+		t.Fatalf("expected sink to observe 6 account updates, got %d", len(sink.updates))
+	}
+
+	// the ring was configured to hold only 1 entry, so only the very last update survives
+	if updates := db.GetBatchUpdates(1); len(updates) != 0 {
+		t.Fatalf("expected batch 1 updates to have been evicted from the ring, got %v", updates)
+	}
+	if updates := db.GetBatchUpdates(3); len(updates) != 1 {
+		t.Fatalf("expected exactly the latest update to remain in the ring, got %v", updates)
+	}
+}
+
+func TestDatabase_RevertToUnknownSnapshot(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+
+	if err := db.PushTransaction(&Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}}); err != nil {
+		t.Fatalf("unexpected error pushing transaction: %v", err)
+	}
+
+	if err := db.RevertToSnapshot(42); err == nil {
+		t.Fatalf("expected an error reverting to a snapshot id that was never taken")
+	}
+}
+
 func public_tester(input1 []int, input2 int, input3 int, input4 string) *Result {
 
 	p := 0
@@ -85,7 +559,7 @@ func public_tester(input1 []int, input2 int, input3 int, input4 string) *Result
 		test_balances = append(test_balances, AccountBalances{accountId: account, balance: balance})
 	}
 
-	db := CreateDatabase(test_balances)
+	db := CreateDatabase(test_balances, DatabaseOptions{ExistentialDeposit: 0})
 	var transactions []Transaction
 	for i := input1[p]; i > 0; i-- {
 		var transaction Transaction
@@ -0,0 +1,276 @@
+package pkg
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TxPool is a priority-ordered staging layer in front of a Database's transaction journal,
+// modeled on go-ethereum's tx_list/tx_pool split. Transactions are admitted per "from" account
+// in nonce order: one that continues the account's sequence is promoted straight to "pending",
+// while one that leaves a gap waits as "queued" until the gap fills. A configured capacity
+// bounds how many transactions may be pending at once by evicting the globally lowest-fee one.
+// TxPool is safe for concurrent use.
+type TxPool struct {
+	db       *Database
+	capacity int // maximum number of pending transactions retained before eviction kicks in; <= 0 means unbounded
+
+	mu       sync.Mutex
+	accounts map[int]*txList // per "from" account nonce bookkeeping
+	pending  pendingHeap     // global fee-sorted heap of pooled transactions ready for Settle
+}
+
+// txList is the per-account nonce index a TxPool keeps, modeled on go-ethereum's tx_list:
+// nextNonce is the lowest nonce this account has not yet had promoted to pending, and queued
+// holds transactions that arrived with a nonce ahead of it, waiting for the gap to fill.
+type txList struct {
+	nextNonce int
+	queued    map[int]*pooledTx // nonce -> tx, for nonce > nextNonce
+}
+
+// pooledTx couples a staged Transaction with the account it was sequenced against and its
+// position in the pending heap.
+type pooledTx struct {
+	tx      *Transaction
+	account int
+	index   int // position in the pending heap, maintained by container/heap
+}
+
+// pendingHeap is a container/heap min-heap over pooled pending transactions, ordered by fee
+// so the lowest-fee entry - the one to evict when the pool is full - is always at the root.
+type pendingHeap []*pooledTx
+
+func (h pendingHeap) Len() int            { return len(h) }
+func (h pendingHeap) Less(i, j int) bool  { return h[i].tx.fee < h[j].tx.fee }
+func (h pendingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pendingHeap) Push(x interface{}) {
+	entry := x.(*pooledTx)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// TxPoolStats reports how many transactions a TxPool is currently holding in each state.
+type TxPoolStats struct {
+	Pending int
+	Queued  int
+}
+
+// CreateTxPool creates a TxPool admitting transactions into db. Once capacity transactions
+// are pending, admitting another evicts the globally lowest-fee one. A capacity <= 0 leaves
+// the pool unbounded.
+func CreateTxPool(db *Database, capacity int) *TxPool {
+	return &TxPool{
+		db:       db,
+		capacity: capacity,
+		accounts: make(map[int]*txList),
+	}
+}
+
+// poolFrom returns the account a TxPool sequences tx against: the "from" of its first
+// transfer. Transaction is otherwise validated by the Database when it is finally pushed.
+func (t *Transaction) poolFrom() (int, error) {
+	if t == nil || len(t.transfers) == 0 {
+		return 0, fmt.Errorf("invalid transaction: no transfers")
+	}
+	return t.transfers[0].from, nil
+}
+
+// PushTransaction admits tx into the pool, sequencing it against the nextNonce of its "from"
+// account: a nonce behind nextNonce is rejected as already applied, a nonce ahead of it is
+// queued until the gap fills, and a nonce that continues the sequence is promoted straight to
+// pending - pulling in, in nonce order, any run of previously queued transactions that now
+// continue from it. If promoting pushes the number of pending transactions over the pool's
+// capacity, the globally lowest-fee pending transactions are evicted until it is back within
+// capacity; if one of them is the transaction just admitted, its own promotion, together with
+// any queued run it pulled in, is undone and PushTransaction returns an error
+// instead, mirroring go-ethereum's handling of an underpriced transaction arriving at a full
+// pool.
+func (p *TxPool) PushTransaction(tx *Transaction) error {
+	account, err := tx.poolFrom()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list, ok := p.accounts[account]
+	if !ok {
+		list = &txList{queued: make(map[int]*pooledTx)}
+		p.accounts[account] = list
+	}
+
+	switch {
+	case tx.nonce < list.nextNonce:
+		return fmt.Errorf("nonce %d for account %d already applied, expected at least %d", tx.nonce, account, list.nextNonce)
+
+	case tx.nonce > list.nextNonce:
+		if existing, queued := list.queued[tx.nonce]; queued && existing.tx.fee >= tx.fee {
+			return fmt.Errorf("nonce %d for account %d is already queued with a fee at least as high", tx.nonce, account)
+		}
+		list.queued[tx.nonce] = &pooledTx{tx: tx, account: account}
+		return nil
+
+	default:
+		p.promote(list, account, tx)
+		return p.evictIfFullLocked(account, tx)
+	}
+}
+
+// promote adds tx to the pending heap as the next nonce for account and then pulls in, in
+// nonce order, any run of previously queued transactions that now continue from it.
+func (p *TxPool) promote(list *txList, account int, tx *Transaction) {
+	heap.Push(&p.pending, &pooledTx{tx: tx, account: account})
+	list.nextNonce++
+
+	for {
+		next, ok := list.queued[list.nextNonce]
+		if !ok {
+			break
+		}
+		delete(list.queued, list.nextNonce)
+		heap.Push(&p.pending, next)
+		list.nextNonce++
+	}
+}
+
+// evictIfFullLocked drops the globally lowest-fee pending transactions while the pool holds more
+// than its configured capacity. If the transaction just admitted is among them, its promotion,
+// together with any queued run it pulled into pending, is undone instead and an error is
+// returned, so a full pool cannot be grown by a transaction too cheap to belong in it.
+func (p *TxPool) evictIfFullLocked(account int, admitted *Transaction) error {
+	for p.capacity > 0 && p.pending.Len() > p.capacity {
+		evicted := heap.Pop(&p.pending).(*pooledTx)
+		if evicted.tx != admitted {
+			continue
+		}
+
+		// the run promote pulled in behind admitted no longer continues the sequence
+		list := p.accounts[account]
+		list.nextNonce = admitted.nonce
+		var pulled []*pooledTx
+		for _, entry := range p.pending {
+			if entry.account == account && entry.tx.nonce > admitted.nonce {
+				pulled = append(pulled, entry)
+			}
+		}
+		for _, entry := range pulled {
+			heap.Remove(&p.pending, entry.index)
+			list.queued[entry.tx.nonce] = entry
+		}
+		return fmt.Errorf("pool is full and fee %d is not high enough to be admitted", admitted.fee)
+	}
+	return nil
+}
+
+// Pending returns every pending transaction, ordered by fee descending - the same order
+// Settle drains them in.
+func (p *TxPool) Pending() []*Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.sortedPendingLocked()
+}
+
+// Queued returns every transaction waiting on an earlier nonce for its account, in no
+// particular order.
+func (p *TxPool) Queued() []*Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var txs []*Transaction
+	for _, list := range p.accounts {
+		for _, entry := range list.queued {
+			txs = append(txs, entry.tx)
+		}
+	}
+	return txs
+}
+
+// Stats reports how many transactions are currently pending and queued across every account.
+func (p *TxPool) Stats() TxPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := TxPoolStats{Pending: p.pending.Len()}
+	for _, list := range p.accounts {
+		stats.Queued += len(list.queued)
+	}
+	return stats
+}
+
+// Settle pushes every pending transaction into the underlying Database, fee-descending, and
+// then commits them with a single Database.Settle call, feeding the existing
+// invalid-transaction detector exactly as a direct Database.PushTransaction caller would.
+// Queued transactions are left in the pool until the nonce gap ahead of them fills. A
+// transaction the Database refuses to admit outright (TxPool never validates accounts itself,
+// only nonce/fee sequencing) is returned to the pool rather than dropped, so one bad
+// transaction can never silently take every other pending transaction down with it.
+func (p *TxPool) Settle() error {
+	p.mu.Lock()
+	txs := p.sortedPendingLocked()
+	p.pending = nil
+	p.mu.Unlock()
+
+	var rejected []*Transaction
+	for _, tx := range txs {
+		if err := p.db.PushTransaction(tx); err != nil {
+			rejected = append(rejected, tx)
+		}
+	}
+	if len(rejected) > 0 {
+		p.requeue(rejected)
+	}
+
+	if err := p.db.Settle(); err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%d pending transaction(s) could not be admitted to the database and were returned to the pool", len(rejected))
+	}
+	return nil
+}
+
+// requeue reinstates pending transactions that Settle drained but the Database refused to
+// admit, putting each straight back into the pending heap at the nonce slot it already
+// occupied rather than re-running nonce sequencing against it.
+func (p *TxPool) requeue(txs []*Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range txs {
+		account, _ := tx.poolFrom()
+		heap.Push(&p.pending, &pooledTx{tx: tx, account: account})
+	}
+}
+
+// sortedPendingLocked returns the pending heap's contents ordered by fee descending. Callers
+// must hold p.mu.
+func (p *TxPool) sortedPendingLocked() []*Transaction {
+	entries := make([]*pooledTx, len(p.pending))
+	copy(entries, p.pending)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tx.fee > entries[j].tx.fee })
+
+	txs := make([]*Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = entry.tx
+	}
+	return txs
+}
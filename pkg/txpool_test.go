@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTxPool_RejectsStaleNonce(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 0)
+
+	tx := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 0}
+	if err := pool.PushTransaction(tx); err != nil {
+		t.Fatalf("unexpected error pushing nonce 0: %v", err)
+	}
+
+	stale := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 0}
+	if err := pool.PushTransaction(stale); err == nil {
+		t.Fatalf("expected an error re-pushing an already-admitted nonce")
+	}
+}
+
+func TestTxPool_QueuesFutureNonceAndPromotesOnceGapFills(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{{accountId: 1, balance: 10}, {accountId: 2, balance: 0}}, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 0)
+
+	future := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 2}
+	if err := pool.PushTransaction(future); err != nil {
+		t.Fatalf("unexpected error queuing nonce 2: %v", err)
+	}
+	if stats := pool.Stats(); stats.Pending != 0 || stats.Queued != 1 {
+		t.Fatalf("expected 1 queued and 0 pending, got %+v", stats)
+	}
+
+	gapFiller := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 1}
+	if err := pool.PushTransaction(gapFiller); err != nil {
+		t.Fatalf("unexpected error queuing nonce 1: %v", err)
+	}
+	if stats := pool.Stats(); stats.Pending != 0 || stats.Queued != 2 {
+		t.Fatalf("expected both transactions still queued behind nonce 0, got %+v", stats)
+	}
+
+	head := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 0}
+	if err := pool.PushTransaction(head); err != nil {
+		t.Fatalf("unexpected error pushing nonce 0: %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.Pending != 3 || stats.Queued != 0 {
+		t.Fatalf("expected the whole run to promote to pending, got %+v", stats)
+	}
+}
+
+func TestTxPool_SettleDrainsPendingFeeDescendingAndLeavesQueued(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 10},
+		{accountId: 3, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 0)
+
+	low := &Transaction{transfers: []Transfer{{from: 1, to: 3, amount: 1}}, nonce: 0, fee: 1}
+	high := &Transaction{transfers: []Transfer{{from: 2, to: 3, amount: 1}}, nonce: 0, fee: 5}
+	queued := &Transaction{transfers: []Transfer{{from: 1, to: 3, amount: 1}}, nonce: 2}
+
+	if err := pool.PushTransaction(low); err != nil {
+		t.Fatalf("unexpected error pushing low fee tx: %v", err)
+	}
+	if err := pool.PushTransaction(high); err != nil {
+		t.Fatalf("unexpected error pushing high fee tx: %v", err)
+	}
+	if err := pool.PushTransaction(queued); err != nil {
+		t.Fatalf("unexpected error queuing future nonce: %v", err)
+	}
+
+	pending := pool.Pending()
+	if len(pending) != 2 || pending[0].fee != 5 || pending[1].fee != 1 {
+		t.Fatalf("expected pending ordered fee descending [5 1], got %v", pending)
+	}
+
+	if err := pool.Settle(); err != nil {
+		t.Fatalf("unexpected error settling: %v", err)
+	}
+
+	balances := db.GetBalances()
+	if balances[1] != 9 || balances[2] != 9 || balances[3] != 2 {
+		t.Fatalf("unexpected balances after settle: %v", balances)
+	}
+	if stats := pool.Stats(); stats.Pending != 0 || stats.Queued != 1 {
+		t.Fatalf("expected the future-nonce tx to remain queued, got %+v", stats)
+	}
+}
+
+func TestTxPool_SettleRequeuesRejectedTransactionsWithoutLosingOthers(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 0)
+
+	valid := &Transaction{transfers: []Transfer{{from: 1, to: 2, amount: 1}}, nonce: 0}
+	// TxPool never validates that referenced accounts exist, so this is admitted into the pool
+	// but the Database will refuse it outright once Settle tries to push it
+	bad := &Transaction{transfers: []Transfer{{from: 99, to: 2, amount: 1}}, nonce: 0}
+
+	if err := pool.PushTransaction(valid); err != nil {
+		t.Fatalf("unexpected error pushing valid tx: %v", err)
+	}
+	if err := pool.PushTransaction(bad); err != nil {
+		t.Fatalf("unexpected error pushing bad tx: %v", err)
+	}
+
+	if err := pool.Settle(); err == nil {
+		t.Fatalf("expected Settle to report the rejected transaction")
+	}
+
+	if balances := db.GetBalances(); balances[1] != 9 || balances[2] != 1 {
+		t.Fatalf("expected the valid transaction to still have been committed, got %v", balances)
+	}
+	if stats := pool.Stats(); stats.Pending != 1 {
+		t.Fatalf("expected the rejected transaction to be returned to the pool, got %+v", stats)
+	}
+}
+
+func TestTxPool_EvictsLowestFeeWhenFullAndRejectsUnderpricedAdmission(t *testing.T) {
+
+	db := CreateDatabase([]AccountBalances{
+		{accountId: 1, balance: 10},
+		{accountId: 2, balance: 10},
+		{accountId: 3, balance: 10},
+		{accountId: 4, balance: 0},
+	}, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 2)
+
+	cheap := &Transaction{transfers: []Transfer{{from: 1, to: 4, amount: 1}}, nonce: 0, fee: 1}
+	mid := &Transaction{transfers: []Transfer{{from: 2, to: 4, amount: 1}}, nonce: 0, fee: 2}
+	if err := pool.PushTransaction(cheap); err != nil {
+		t.Fatalf("unexpected error pushing cheap tx: %v", err)
+	}
+	if err := pool.PushTransaction(mid); err != nil {
+		t.Fatalf("unexpected error pushing mid tx: %v", err)
+	}
+
+	// the pool is at capacity; a transaction cheaper than every admitted one must be rejected
+	// outright rather than evicting something else to make room for it
+	underpriced := &Transaction{transfers: []Transfer{{from: 3, to: 4, amount: 1}}, nonce: 0, fee: 0}
+	if err := pool.PushTransaction(underpriced); err == nil {
+		t.Fatalf("expected an underpriced transaction to be rejected by a full pool")
+	}
+
+	expensive := &Transaction{transfers: []Transfer{{from: 3, to: 4, amount: 1}}, nonce: 0, fee: 9}
+	if err := pool.PushTransaction(expensive); err != nil {
+		t.Fatalf("unexpected error pushing expensive tx: %v", err)
+	}
+
+	pending := pool.Pending()
+	if len(pending) != 2 || pending[0].fee != 9 || pending[1].fee != 2 {
+		t.Fatalf("expected the cheapest admitted tx to have been evicted, got %v", pending)
+	}
+}
+
+func TestTxPool_ConcurrentPushTransactionIsSafe(t *testing.T) {
+
+	accounts := make([]AccountBalances, 0, 20)
+	for i := 1; i <= 20; i++ {
+		accounts = append(accounts, AccountBalances{accountId: i, balance: 10})
+	}
+	db := CreateDatabase(accounts, DatabaseOptions{ExistentialDeposit: 0})
+	pool := CreateTxPool(db, 0)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(accountId int) {
+			defer wg.Done()
+			tx := &Transaction{transfers: []Transfer{{from: accountId, to: 1, amount: 1}}, nonce: 0}
+			_ = pool.PushTransaction(tx)
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := pool.Stats(); stats.Pending != 20 {
+		t.Fatalf("expected all 20 independent-account transactions to be pending, got %+v", stats)
+	}
+}